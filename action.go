@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+const confirmPageName = "confirm"
+
+// actionName identifies one of the mutating admin commands available in
+// action mode. It doubles as the string written to the audit log.
+type actionName string
+
+const (
+	actionPause     actionName = "pause"
+	actionUnpause   actionName = "unpause"
+	actionEmpty     actionName = "empty"
+	actionDelete    actionName = "delete"
+	actionTombstone actionName = "tombstone"
+)
+
+// handleActionKey runs the action-mode command bound to r against the
+// currently selected row, after the user confirms it in a modal. It's a
+// no-op unless n.actionsEnabled and a row is selected.
+func (n *NSQTop) handleActionKey(r rune) bool {
+	if !n.actionsEnabled || n.snapshot == nil {
+		return false
+	}
+
+	row, _ := n.table.GetSelection()
+	if row < 1 || row-1 >= len(n.rows) {
+		return false
+	}
+	ref := n.rows[row-1]
+	if ref.host != "" {
+		return false // no admin actions against a raw nsqd-view row
+	}
+
+	switch r {
+	case 'P':
+		n.confirmAction(actionPause, ref)
+	case 'U':
+		n.confirmAction(actionUnpause, ref)
+	case 'E':
+		n.confirmAction(actionEmpty, ref)
+	case 'D':
+		n.confirmAction(actionDelete, ref)
+	case 'T':
+		if ref.channel == "" {
+			n.confirmAction(actionTombstone, ref)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func (n *NSQTop) confirmAction(action actionName, ref rowRef) {
+	target := ref.topic
+	if ref.channel != "" {
+		target = ref.topic + "/" + ref.channel
+	}
+
+	// Resolve the target hosts here, on the tview event-loop goroutine,
+	// while n.snapshot is safe to read. runAction must not touch
+	// n.snapshot itself since it runs on a background goroutine and
+	// updateData() replaces n.snapshot concurrently from the event loop.
+	hosts := n.targetHosts(ref.topic, ref.channel)
+
+	label := string(action)
+	prompt := fmt.Sprintf("%s%s %s?", strings.ToUpper(label[:1]), label[1:], target)
+	modal := tview.NewModal().
+		SetText(prompt).
+		AddButtons([]string{"Yes", "No"})
+	modal.SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+		n.pages.RemovePage(confirmPageName)
+		n.app.SetFocus(n.table)
+		if buttonLabel != "Yes" {
+			return
+		}
+		go n.runAction(action, ref, hosts)
+	})
+
+	n.pages.AddPage(confirmPageName, modal, true, true)
+	n.app.SetFocus(modal)
+}
+
+// runAction executes action against every host in hosts (nsqd nodes
+// currently serving ref's topic/channel, resolved by the caller before
+// this ran on a background goroutine), audits each attempt, and shows a
+// summary in the detail pane.
+func (n *NSQTop) runAction(action actionName, ref rowRef, hosts []string) {
+	ctx := context.Background()
+
+	var results []string
+	if action == actionTombstone {
+		// Tombstone must be issued once per nsqd host; nsqlookupd's
+		// /topic/tombstone_topic_producer takes exactly one producer, not
+		// a comma-joined list.
+		for _, host := range hosts {
+			status, err := n.lookupdClient.TombstoneTopicProducer(ctx, ref.topic, host)
+			n.auditAction(action, ref, "lookupd->"+host, status, err)
+			results = append(results, fmt.Sprintf("lookupd tombstone %s: %s", host, outcomeText(status, err)))
+		}
+	} else {
+		for _, host := range hosts {
+			status, err := n.dispatchAction(ctx, action, ref, host)
+			n.auditAction(action, ref, host, status, err)
+			results = append(results, fmt.Sprintf("%s: %s", host, outcomeText(status, err)))
+		}
+	}
+
+	target := ref.topic
+	if ref.channel != "" {
+		target = ref.topic + "/" + ref.channel
+	}
+
+	n.app.QueueUpdateDraw(func() {
+		n.showDetail(fmt.Sprintf("[yellow]%s %s[white]\n\n%s", action, target, strings.Join(results, "\n")))
+	})
+}
+
+func outcomeText(status string, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if status != "" {
+		return status
+	}
+	return "OK"
+}
+
+func (n *NSQTop) dispatchAction(ctx context.Context, action actionName, ref rowRef, host string) (string, error) {
+	addr, port, err := splitHostPort(host)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.channel != "" {
+		return n.dispatchChannelAction(ctx, action, addr, port, ref.topic, ref.channel)
+	}
+	return n.dispatchTopicAction(ctx, action, addr, port, ref.topic)
+}
+
+func (n *NSQTop) dispatchChannelAction(ctx context.Context, action actionName, addr string, port int, topic, channel string) (string, error) {
+	c := n.nsqdClient
+	switch action {
+	case actionPause:
+		return c.PauseChannel(ctx, addr, port, topic, channel)
+	case actionUnpause:
+		return c.UnpauseChannel(ctx, addr, port, topic, channel)
+	case actionEmpty:
+		return c.EmptyChannel(ctx, addr, port, topic, channel)
+	case actionDelete:
+		return c.DeleteChannel(ctx, addr, port, topic, channel)
+	default:
+		return "", fmt.Errorf("unsupported channel action %q", action)
+	}
+}
+
+func (n *NSQTop) dispatchTopicAction(ctx context.Context, action actionName, addr string, port int, topic string) (string, error) {
+	c := n.nsqdClient
+	switch action {
+	case actionPause:
+		return c.PauseTopic(ctx, addr, port, topic)
+	case actionUnpause:
+		return c.UnpauseTopic(ctx, addr, port, topic)
+	case actionEmpty:
+		return c.EmptyTopic(ctx, addr, port, topic)
+	case actionDelete:
+		return c.DeleteTopic(ctx, addr, port, topic)
+	default:
+		return "", fmt.Errorf("unsupported topic action %q", action)
+	}
+}
+
+// targetHosts returns the "host:port" of every nsqd node currently
+// serving topic (and, if channel is non-empty, that specific channel).
+//
+// Topic-level targets (channel == "") are resolved from the topic's own
+// Hosts rather than from its channels: a topic can exist, and receive
+// publishes, with zero channels connected, so deriving hosts from
+// channels would silently no-op a pause/empty/delete/tombstone against
+// such a topic.
+func (n *NSQTop) targetHosts(topic, channel string) []string {
+	if channel == "" {
+		for _, t := range n.snapshot.Topics {
+			if t.Topic == topic {
+				return t.Hosts
+			}
+		}
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, ch := range n.snapshot.Channels {
+		if ch.Topic != topic || ch.Channel != channel {
+			continue
+		}
+		for _, h := range ch.Hosts {
+			if !seen[h.Host] {
+				seen[h.Host] = true
+				hosts = append(hosts, h.Host)
+			}
+		}
+	}
+	return hosts
+}
+
+func splitHostPort(host string) (string, int, error) {
+	addr, portStr, err := net.SplitHostPort(host)
+	if err != nil {
+		return "", 0, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q: %w", host, err)
+	}
+	return addr, port, nil
+}
+
+// auditAction writes one line per action attempt to the audit log:
+// timestamp (via the logger's own prefix), user, action, target, node,
+// and the actual HTTP response (or error if the request never got one).
+func (n *NSQTop) auditAction(action actionName, ref rowRef, node, status string, err error) {
+	if n.auditLog == nil {
+		return
+	}
+
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "unknown"
+	}
+
+	response := status
+	if err != nil {
+		response = err.Error()
+	} else if response == "" {
+		response = "OK"
+	}
+
+	n.auditLog.Printf("user=%s action=%s topic=%q channel=%q node=%s response=%s",
+		user, action, ref.topic, ref.channel, node, response)
+}
@@ -0,0 +1,496 @@
+// Package cluster scrapes nsqlookupd and nsqd for cluster-wide stats and
+// aggregates them into a strongly-typed ClusterSnapshot. It is the single
+// source of truth consumed by both the interactive tview UI and the
+// non-interactive exporter/snapshot modes.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/FleetChaser/nsqtop/http_api"
+	"github.com/FleetChaser/nsqtop/lookupd"
+	"github.com/FleetChaser/nsqtop/nsqd"
+)
+
+// Producer re-exports lookupd.Producer so callers of cluster don't need to
+// import the lookupd package directly.
+type Producer = lookupd.Producer
+
+// LatencyPercentiles is an e2e_processing_latency histogram aggregated
+// across every nsqd host that reported one. Aggregation is a simple
+// average of each host's value at a given quantile; it's an approximation
+// (not a true merged percentile, which would need the raw per-host
+// histograms), but is good enough to spot a slow host.
+type LatencyPercentiles struct {
+	P50  time.Duration
+	P95  time.Duration
+	P99  time.Duration
+	P999 time.Duration
+}
+
+// HostChannelStats is a single nsqd's contribution to a channel, retained
+// alongside the cluster-wide totals so the drill-down view can show a
+// per-host breakdown without re-querying nsqd.
+type HostChannelStats struct {
+	Host          string // "broadcast_address:http_port"
+	Depth         int
+	BackendDepth  int
+	InFlightCount int
+	DeferredCount int
+	MessageCount  int64
+	RequeueCount  int64
+	TimeoutCount  int64
+	ClientCount   int
+	Paused        bool
+}
+
+// ChannelStats is the aggregated, cluster-wide view of a single
+// topic/channel pair as of the most recent Scrape.
+type ChannelStats struct {
+	Topic         string
+	Channel       string
+	Depth         int
+	InFlightCount int
+	MessageCount  int64
+	RatePerSecond float64 // instantaneous rate over the last scrape interval
+
+	// RateEWMA1m/5m/15m are load-average-style exponentially weighted
+	// moving averages of RatePerSecond, summed across hosts. They smooth
+	// out the noise a single short interval produces.
+	RateEWMA1m  float64
+	RateEWMA5m  float64
+	RateEWMA15m float64
+
+	Latency LatencyPercentiles
+	Hosts   []HostChannelStats
+
+	// History holds the last few RatePerSecond samples, oldest first, for
+	// drawing a per-row sparkline.
+	History []float64
+}
+
+// TopicStats is the aggregated, cluster-wide view of a topic, summed over
+// its channels.
+type TopicStats struct {
+	Topic         string
+	Depth         int
+	InFlightCount int
+	MessageCount  int64
+	RatePerSecond float64
+
+	RateEWMA1m  float64
+	RateEWMA5m  float64
+	RateEWMA15m float64
+
+	ChannelCount int
+	Latency      LatencyPercentiles
+
+	// Hosts is every nsqd "broadcast_address:http_port" that reported
+	// this topic, independent of its channels. A topic can exist (and
+	// need pausing/emptying/deleting/tombstoning) with zero channels, so
+	// admin actions must resolve targets from here rather than from the
+	// topic's channels.
+	Hosts []string
+}
+
+// ClusterSnapshot is the strongly-typed result of a single Scrape.
+type ClusterSnapshot struct {
+	Timestamp     time.Time
+	Nodes         []Producer
+	Channels      []ChannelStats
+	Topics        []TopicStats
+	TotalDepth    int
+	TotalInFlight int
+}
+
+const defaultNSQDConcurrency = 16
+
+// historyLength is how many RatePerSecond samples are retained per channel
+// for the per-row sparkline.
+const historyLength = 60
+
+// EWMA windows, in the style of Unix load averages.
+const (
+	ewmaWindow1m  = time.Minute
+	ewmaWindow5m  = 5 * time.Minute
+	ewmaWindow15m = 15 * time.Minute
+)
+
+// counterState tracks a single nsqd host's message-count counter for one
+// topic/channel, so a restart (counter reset) can be detected and doesn't
+// produce a bogus negative rate.
+type counterState struct {
+	lastCount   int64
+	ewma1m      float64
+	ewma5m      float64
+	ewma15m     float64
+	initialized bool
+
+	// missedScrapes counts consecutive scrapes in which this host/topic/
+	// channel wasn't reported. It's reset to 0 whenever the counter is
+	// seen again, and the entry is only pruned once it crosses
+	// maxMissedScrapes, so one slow or failed scrape doesn't reset the
+	// EWMAs that the retry logic in the nsqd client is meant to protect.
+	missedScrapes int
+}
+
+// maxMissedScrapes is how many consecutive scrapes a host/topic/channel or
+// channel-history entry can go unseen before it's pruned. A single miss is
+// usually just a slow or transiently failed nsqd request, not a host that
+// actually left the cluster.
+const maxMissedScrapes = 5
+
+// Scraper polls one or more nsqlookupd instances for nsqd nodes, then polls
+// each nsqd's /stats endpoint and aggregates the results per topic/channel.
+// A Scraper is safe for concurrent use; it keeps per-host counter state and
+// per-channel rate history across calls to Scrape.
+type Scraper struct {
+	lookupd *lookupd.Client
+	nsqd    *nsqd.Client
+
+	// Concurrency bounds how many nsqd nodes are queried at once per
+	// Scrape.
+	Concurrency int
+
+	mu             sync.Mutex
+	hostCounters   map[string]*counterState // "host/topic/channel" -> counter state
+	channelHistory map[string][]float64     // "topic/channel" -> RatePerSecond samples
+	channelMisses  map[string]int           // "topic/channel" -> consecutive scrapes unseen
+	lastScrapeAt   time.Time
+}
+
+// NewScraper builds a Scraper that discovers nsqd nodes via the given
+// nsqlookupd HTTP addresses and queries both nsqlookupd and nsqd through
+// api. The scheme and any basic-auth credentials present on the first
+// lookupd URL are reused when querying individual nsqd nodes, since
+// nsqlookupd never reports a scheme or credentials for the nodes it
+// returns.
+func NewScraper(lookupURLs []string, api *http_api.Client) (*Scraper, error) {
+	scheme := "http"
+	var userinfo *url.Userinfo
+	if len(lookupURLs) > 0 {
+		parsed, err := url.Parse(lookupURLs[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid lookupd address %q: %w", lookupURLs[0], err)
+		}
+		scheme = parsed.Scheme
+		userinfo = parsed.User
+	}
+
+	return &Scraper{
+		lookupd:        lookupd.NewClient(lookupURLs, api),
+		nsqd:           nsqd.NewClient(api, scheme, userinfo),
+		Concurrency:    defaultNSQDConcurrency,
+		hostCounters:   make(map[string]*counterState),
+		channelHistory: make(map[string][]float64),
+		channelMisses:  make(map[string]int),
+	}, nil
+}
+
+// NSQDClient returns the nsqd.Client the Scraper uses to query nsqd
+// stats, so other callers (e.g. action mode's admin commands) can reuse
+// the same scheme/auth configuration instead of building their own.
+func (s *Scraper) NSQDClient() *nsqd.Client {
+	return s.nsqd
+}
+
+// LookupdClient returns the lookupd.Client the Scraper uses to discover
+// nsqd nodes.
+func (s *Scraper) LookupdClient() *lookupd.Client {
+	return s.lookupd
+}
+
+// Scrape discovers the cluster's nsqd nodes via nsqlookupd, queries each
+// node's stats concurrently, and returns the aggregated snapshot.
+func (s *Scraper) Scrape(ctx context.Context) (*ClusterSnapshot, error) {
+	nodes, err := s.lookupd.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statsPerNode := make([]*nsqd.StatsResponse, len(nodes))
+	http_api.RunPool(len(nodes), s.Concurrency, func(i int) {
+		stats, err := s.nsqd.GetStats(ctx, nodes[i].BroadcastAddress, nodes[i].HTTPPort)
+		if err != nil {
+			return // Ignore failed nodes
+		}
+		statsPerNode[i] = stats
+	})
+
+	channels, topics, totalInflight := s.processStats(nodes, statsPerNode)
+
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Depth > channels[j].Depth
+	})
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Depth > topics[j].Depth
+	})
+
+	totalDepth := 0
+	for _, ch := range channels {
+		totalDepth += ch.Depth
+	}
+
+	return &ClusterSnapshot{
+		Timestamp:     time.Now(),
+		Nodes:         nodes,
+		Channels:      channels,
+		Topics:        topics,
+		TotalDepth:    totalDepth,
+		TotalInFlight: totalInflight,
+	}, nil
+}
+
+// channelAgg accumulates a single topic/channel pair's stats across every
+// nsqd host reporting it, retaining per-host identity and raw latency
+// histograms until the final aggregation pass.
+type channelAgg struct {
+	stats     ChannelStats
+	hosts     []HostChannelStats
+	latencies []nsqd.E2EProcessingLatency
+}
+
+// topicAgg accumulates a single topic's stats across every host and
+// channel reporting it.
+type topicAgg struct {
+	stats       TopicStats
+	latencies   []nsqd.E2EProcessingLatency
+	channelKeys map[string]bool
+	hostsSeen   map[string]bool
+}
+
+func (s *Scraper) processStats(nodes []Producer, statsPerNode []*nsqd.StatsResponse) ([]ChannelStats, []TopicStats, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channelAggs := make(map[string]*channelAgg)
+	topicAggs := make(map[string]*topicAgg)
+	totalInflight := 0
+
+	for i, stats := range statsPerNode {
+		if stats == nil {
+			continue
+		}
+		host := fmt.Sprintf("%s:%d", nodes[i].BroadcastAddress, nodes[i].HTTPPort)
+
+		for _, topic := range stats.Topics {
+			tAgg, exists := topicAggs[topic.TopicName]
+			if !exists {
+				tAgg = &topicAgg{
+					stats:       TopicStats{Topic: topic.TopicName},
+					channelKeys: make(map[string]bool),
+					hostsSeen:   make(map[string]bool),
+				}
+				topicAggs[topic.TopicName] = tAgg
+			}
+			tAgg.latencies = append(tAgg.latencies, topic.E2EProcessingLatency)
+
+			// Record this host against the topic regardless of whether it
+			// has any channels: a topic can exist, and need pausing or
+			// deleting, before any consumer has connected a channel to it.
+			if !tAgg.hostsSeen[host] {
+				tAgg.hostsSeen[host] = true
+				tAgg.stats.Hosts = append(tAgg.stats.Hosts, host)
+			}
+
+			for _, channel := range topic.Channels {
+				key := fmt.Sprintf("%s/%s", topic.TopicName, channel.ChannelName)
+
+				cAgg, exists := channelAggs[key]
+				if !exists {
+					cAgg = &channelAgg{
+						stats: ChannelStats{Topic: topic.TopicName, Channel: channel.ChannelName},
+					}
+					channelAggs[key] = cAgg
+				}
+
+				cAgg.stats.Depth += channel.Depth + channel.BackendDepth
+				cAgg.stats.InFlightCount += channel.InFlightCount
+				cAgg.stats.MessageCount += channel.MessageCount
+				cAgg.latencies = append(cAgg.latencies, channel.E2EProcessingLatency)
+				cAgg.hosts = append(cAgg.hosts, HostChannelStats{
+					Host:          host,
+					Depth:         channel.Depth,
+					BackendDepth:  channel.BackendDepth,
+					InFlightCount: channel.InFlightCount,
+					DeferredCount: channel.DeferredCount,
+					MessageCount:  channel.MessageCount,
+					RequeueCount:  channel.RequeueCount,
+					TimeoutCount:  channel.TimeoutCount,
+					ClientCount:   len(channel.Clients),
+					Paused:        channel.Paused,
+				})
+
+				totalInflight += channel.InFlightCount
+
+				if !tAgg.channelKeys[key] {
+					tAgg.channelKeys[key] = true
+					tAgg.stats.ChannelCount++
+				}
+				tAgg.stats.Depth += channel.Depth + channel.BackendDepth
+				tAgg.stats.InFlightCount += channel.InFlightCount
+				tAgg.stats.MessageCount += channel.MessageCount
+			}
+		}
+	}
+
+	// Calculate channel rates per nsqd host, so a single host's counter
+	// reset (e.g. a restart) doesn't corrupt every other host's rate.
+	now := time.Now()
+	elapsed := now.Sub(s.lastScrapeAt).Seconds()
+	haveBaseline := !s.lastScrapeAt.IsZero() && elapsed > 0
+
+	channels := make([]ChannelStats, 0, len(channelAggs))
+	topicRates := make(map[string][3]float64) // topic -> [ewma1m, ewma5m, ewma15m]
+	topicInstantRates := make(map[string]float64)
+
+	// Track every counter/history key touched by this scrape so stale
+	// entries for hosts/channels that have since disappeared (node
+	// removed, topic deleted) can be pruned below, rather than growing
+	// s.hostCounters/s.channelHistory forever.
+	seenCounters := make(map[string]bool)
+	seenChannels := make(map[string]bool, len(channelAggs))
+
+	for key, agg := range channelAggs {
+		cs := agg.stats
+		cs.Hosts = agg.hosts
+		cs.Latency = aggregateLatency(agg.latencies)
+		seenChannels[key] = true
+
+		for _, host := range cs.Hosts {
+			counterKey := host.Host + "/" + key
+			seenCounters[counterKey] = true
+
+			instant, ewma1m, ewma5m, ewma15m := s.updateCounter(counterKey, host.MessageCount, elapsed, haveBaseline)
+
+			cs.RatePerSecond += instant
+			cs.RateEWMA1m += ewma1m
+			cs.RateEWMA5m += ewma5m
+			cs.RateEWMA15m += ewma15m
+		}
+
+		history := append(s.channelHistory[key], cs.RatePerSecond)
+		if len(history) > historyLength {
+			history = history[len(history)-historyLength:]
+		}
+		s.channelHistory[key] = history
+		cs.History = append([]float64(nil), history...)
+
+		rates := topicRates[cs.Topic]
+		rates[0] += cs.RateEWMA1m
+		rates[1] += cs.RateEWMA5m
+		rates[2] += cs.RateEWMA15m
+		topicRates[cs.Topic] = rates
+		topicInstantRates[cs.Topic] += cs.RatePerSecond
+
+		channels = append(channels, cs)
+	}
+
+	for counterKey, state := range s.hostCounters {
+		if seenCounters[counterKey] {
+			continue
+		}
+		state.missedScrapes++
+		if state.missedScrapes >= maxMissedScrapes {
+			delete(s.hostCounters, counterKey)
+		}
+	}
+	for channelKey := range s.channelHistory {
+		if seenChannels[channelKey] {
+			delete(s.channelMisses, channelKey)
+			continue
+		}
+		s.channelMisses[channelKey]++
+		if s.channelMisses[channelKey] >= maxMissedScrapes {
+			delete(s.channelHistory, channelKey)
+			delete(s.channelMisses, channelKey)
+		}
+	}
+
+	s.lastScrapeAt = now
+
+	topics := make([]TopicStats, 0, len(topicAggs))
+	for _, agg := range topicAggs {
+		ts := agg.stats
+		ts.Latency = aggregateLatency(agg.latencies)
+		ts.RatePerSecond = topicInstantRates[ts.Topic]
+		rates := topicRates[ts.Topic]
+		ts.RateEWMA1m = rates[0]
+		ts.RateEWMA5m = rates[1]
+		ts.RateEWMA15m = rates[2]
+		topics = append(topics, ts)
+	}
+
+	return channels, topics, totalInflight
+}
+
+// updateCounter applies a single nsqd host's latest message-count reading
+// to its tracked counter state, keyed by counterKey ("host/topic/channel"),
+// detecting restarts (current < previous) and rebasing the baseline
+// instead of producing a negative rate. It returns the instantaneous
+// rate for this tick plus the updated 1m/5m/15m EWMAs.
+func (s *Scraper) updateCounter(counterKey string, currentCount int64, elapsedSeconds float64, haveBaseline bool) (instant, ewma1m, ewma5m, ewma15m float64) {
+	state, exists := s.hostCounters[counterKey]
+	if !exists {
+		state = &counterState{}
+		s.hostCounters[counterKey] = state
+	}
+
+	if haveBaseline && exists && state.initialized && elapsedSeconds > 0 {
+		diff := currentCount - state.lastCount
+		if diff >= 0 {
+			instant = float64(diff) / elapsedSeconds
+		}
+		// A negative diff means the nsqd counter was reset (restart); fall
+		// through with instant left at 0 rather than reporting garbage.
+
+		alpha1m := 1 - math.Exp(-elapsedSeconds/ewmaWindow1m.Seconds())
+		alpha5m := 1 - math.Exp(-elapsedSeconds/ewmaWindow5m.Seconds())
+		alpha15m := 1 - math.Exp(-elapsedSeconds/ewmaWindow15m.Seconds())
+
+		state.ewma1m = alpha1m*instant + (1-alpha1m)*state.ewma1m
+		state.ewma5m = alpha5m*instant + (1-alpha5m)*state.ewma5m
+		state.ewma15m = alpha15m*instant + (1-alpha15m)*state.ewma15m
+	}
+
+	state.lastCount = currentCount
+	state.initialized = true
+	state.missedScrapes = 0
+
+	return instant, state.ewma1m, state.ewma5m, state.ewma15m
+}
+
+// aggregateLatency averages each quantile across every host that reported
+// it. A host missing a quantile (e.g. because it has no e2e latency
+// percentiles configured) is simply excluded from that quantile's average.
+func aggregateLatency(perHost []nsqd.E2EProcessingLatency) LatencyPercentiles {
+	sums := make(map[float64]int64)
+	counts := make(map[float64]int)
+
+	for _, lat := range perHost {
+		for _, p := range lat.Percentiles {
+			sums[p.Quantile] += p.Value
+			counts[p.Quantile]++
+		}
+	}
+
+	avg := func(quantile float64) time.Duration {
+		if counts[quantile] == 0 {
+			return 0
+		}
+		return time.Duration(sums[quantile] / int64(counts[quantile]))
+	}
+
+	return LatencyPercentiles{
+		P50:  avg(0.50),
+		P95:  avg(0.95),
+		P99:  avg(0.99),
+		P999: avg(0.999),
+	}
+}
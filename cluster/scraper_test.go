@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/FleetChaser/nsqtop/nsqd"
+)
+
+func TestUpdateCounterFirstSampleHasNoBaseline(t *testing.T) {
+	s := &Scraper{hostCounters: make(map[string]*counterState)}
+
+	instant, ewma1m, ewma5m, ewma15m := s.updateCounter("host:4151/t/c", 100, 10, false)
+	if instant != 0 || ewma1m != 0 || ewma5m != 0 || ewma15m != 0 {
+		t.Errorf("first sample should produce no rate, got instant=%v ewma1m=%v ewma5m=%v ewma15m=%v",
+			instant, ewma1m, ewma5m, ewma15m)
+	}
+}
+
+func TestUpdateCounterSteadyRate(t *testing.T) {
+	s := &Scraper{hostCounters: make(map[string]*counterState)}
+
+	s.updateCounter("host:4151/t/c", 0, 10, false)
+	instant, _, _, _ := s.updateCounter("host:4151/t/c", 100, 10, true)
+
+	if instant != 10 {
+		t.Errorf("instant rate = %v; expected 10 (100 messages / 10s)", instant)
+	}
+}
+
+func TestUpdateCounterResetProducesZeroNotNegative(t *testing.T) {
+	s := &Scraper{hostCounters: make(map[string]*counterState)}
+
+	s.updateCounter("host:4151/t/c", 1000, 10, false)
+	instant, _, _, _ := s.updateCounter("host:4151/t/c", 5, 10, true)
+
+	if instant != 0 {
+		t.Errorf("instant rate after a counter reset = %v; expected 0, not a negative rate", instant)
+	}
+
+	// The baseline should have rebased to the post-reset count, so the next
+	// tick reports a rate computed off 5, not off the pre-reset 1000.
+	instant, _, _, _ = s.updateCounter("host:4151/t/c", 15, 10, true)
+	if instant != 1 {
+		t.Errorf("instant rate after rebasing = %v; expected 1 (10 messages / 10s)", instant)
+	}
+}
+
+func TestUpdateCounterEWMAConvergesToSteadyRate(t *testing.T) {
+	s := &Scraper{hostCounters: make(map[string]*counterState)}
+
+	const rate = 50.0
+	const tickSeconds = 10.0
+
+	var count int64
+	var ewma1m float64
+	s.updateCounter("host:4151/t/c", count, tickSeconds, false)
+
+	for i := 0; i < 60; i++ {
+		count += int64(rate * tickSeconds)
+		_, ewma1m, _, _ = s.updateCounter("host:4151/t/c", count, tickSeconds, true)
+	}
+
+	if math.Abs(ewma1m-rate) > 1 {
+		t.Errorf("1m EWMA after sustained constant rate = %v; expected to converge near %v", ewma1m, rate)
+	}
+}
+
+func TestUpdateCounterResetsMissedScrapesOnSuccess(t *testing.T) {
+	s := &Scraper{hostCounters: make(map[string]*counterState)}
+
+	s.updateCounter("host:4151/t/c", 0, 10, false)
+	state := s.hostCounters["host:4151/t/c"]
+	state.missedScrapes = maxMissedScrapes - 1
+
+	s.updateCounter("host:4151/t/c", 10, 10, true)
+	if state.missedScrapes != 0 {
+		t.Errorf("missedScrapes = %d after a successful scrape; expected 0", state.missedScrapes)
+	}
+}
+
+func TestAggregateLatencyAveragesAcrossHosts(t *testing.T) {
+	perHost := []nsqd.E2EProcessingLatency{
+		{Percentiles: []nsqd.Percentile{{Quantile: 0.50, Value: 100}, {Quantile: 0.99, Value: 900}}},
+		{Percentiles: []nsqd.Percentile{{Quantile: 0.50, Value: 200}}},
+	}
+
+	latency := aggregateLatency(perHost)
+
+	if latency.P50 != 150*time.Nanosecond {
+		t.Errorf("P50 = %v; expected 150ns average of 100ns and 200ns", latency.P50)
+	}
+	if latency.P99 != 900*time.Nanosecond {
+		t.Errorf("P99 = %v; expected 900ns (only host reporting it)", latency.P99)
+	}
+	if latency.P95 != 0 {
+		t.Errorf("P95 = %v; expected 0 since no host reported it", latency.P95)
+	}
+}
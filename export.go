@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FleetChaser/nsqtop/cluster"
+)
+
+// runMetricsExporter starts an HTTP server on listenAddr that serves the
+// cluster's aggregated stats in Prometheus exposition format on /metrics.
+// Each request triggers a fresh scrape; it blocks until the server exits.
+func runMetricsExporter(scraper *cluster.Scraper, listenAddr string, interval time.Duration) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := scraper.Scrape(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, formatPrometheus(snapshot))
+	})
+
+	log.Printf("Serving Prometheus metrics on %s/metrics (each request scrapes the cluster)", listenAddr)
+	if err := http.ListenAndServe(listenAddr, mux); err != nil {
+		log.Fatalf("metrics exporter failed: %v", err)
+	}
+}
+
+// formatPrometheus renders a ClusterSnapshot as Prometheus text exposition
+// format (https://prometheus.io/docs/instrumenting/exposition_formats/).
+func formatPrometheus(snapshot *cluster.ClusterSnapshot) string {
+	var b strings.Builder
+
+	gauge := func(name, help string, write func()) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		write()
+	}
+
+	gauge("nsqtop_channel_depth", "Current queue depth (depth + backend_depth) for a channel.", func() {
+		for _, ch := range snapshot.Channels {
+			fmt.Fprintf(&b, "nsqtop_channel_depth{topic=%q,channel=%q} %d\n", ch.Topic, ch.Channel, ch.Depth)
+		}
+	})
+
+	gauge("nsqtop_channel_backend_depth", "Portion of a channel's depth that has spilled to disk, summed across hosts.", func() {
+		for _, ch := range snapshot.Channels {
+			var backendDepth int
+			for _, host := range ch.Hosts {
+				backendDepth += host.BackendDepth
+			}
+			fmt.Fprintf(&b, "nsqtop_channel_backend_depth{topic=%q,channel=%q} %d\n", ch.Topic, ch.Channel, backendDepth)
+		}
+	})
+
+	gauge("nsqtop_channel_in_flight_count", "Messages currently in flight for a channel.", func() {
+		for _, ch := range snapshot.Channels {
+			fmt.Fprintf(&b, "nsqtop_channel_in_flight_count{topic=%q,channel=%q} %d\n", ch.Topic, ch.Channel, ch.InFlightCount)
+		}
+	})
+
+	gauge("nsqtop_channel_message_count", "Total messages ever put on a channel.", func() {
+		for _, ch := range snapshot.Channels {
+			fmt.Fprintf(&b, "nsqtop_channel_message_count{topic=%q,channel=%q} %d\n", ch.Topic, ch.Channel, ch.MessageCount)
+		}
+	})
+
+	gauge("nsqtop_channel_rate_per_second", "Message rate per second for a channel since the previous scrape.", func() {
+		for _, ch := range snapshot.Channels {
+			fmt.Fprintf(&b, "nsqtop_channel_rate_per_second{topic=%q,channel=%q} %.4f\n", ch.Topic, ch.Channel, ch.RatePerSecond)
+		}
+	})
+
+	gauge("nsqtop_channel_rate_ewma", "Exponentially weighted moving average of message rate per second for a channel, load-average style.", func() {
+		for _, ch := range snapshot.Channels {
+			fmt.Fprintf(&b, "nsqtop_channel_rate_ewma{topic=%q,channel=%q,window=\"1m\"} %.4f\n", ch.Topic, ch.Channel, ch.RateEWMA1m)
+			fmt.Fprintf(&b, "nsqtop_channel_rate_ewma{topic=%q,channel=%q,window=\"5m\"} %.4f\n", ch.Topic, ch.Channel, ch.RateEWMA5m)
+			fmt.Fprintf(&b, "nsqtop_channel_rate_ewma{topic=%q,channel=%q,window=\"15m\"} %.4f\n", ch.Topic, ch.Channel, ch.RateEWMA15m)
+		}
+	})
+
+	gauge("nsqtop_host_channel_depth", "Current queue depth on a single nsqd host for a channel.", func() {
+		for _, ch := range snapshot.Channels {
+			for _, host := range ch.Hosts {
+				fmt.Fprintf(&b, "nsqtop_host_channel_depth{topic=%q,channel=%q,host=%q} %d\n", ch.Topic, ch.Channel, host.Host, host.Depth)
+			}
+		}
+	})
+
+	gauge("nsqtop_host_channel_backend_depth", "Portion of a channel's depth that has spilled to disk on a single nsqd host.", func() {
+		for _, ch := range snapshot.Channels {
+			for _, host := range ch.Hosts {
+				fmt.Fprintf(&b, "nsqtop_host_channel_backend_depth{topic=%q,channel=%q,host=%q} %d\n", ch.Topic, ch.Channel, host.Host, host.BackendDepth)
+			}
+		}
+	})
+
+	gauge("nsqtop_host_channel_in_flight_count", "Messages currently in flight on a single nsqd host for a channel.", func() {
+		for _, ch := range snapshot.Channels {
+			for _, host := range ch.Hosts {
+				fmt.Fprintf(&b, "nsqtop_host_channel_in_flight_count{topic=%q,channel=%q,host=%q} %d\n", ch.Topic, ch.Channel, host.Host, host.InFlightCount)
+			}
+		}
+	})
+
+	gauge("nsqtop_host_channel_message_count", "Total messages ever put on a channel, as seen by a single nsqd host.", func() {
+		for _, ch := range snapshot.Channels {
+			for _, host := range ch.Hosts {
+				fmt.Fprintf(&b, "nsqtop_host_channel_message_count{topic=%q,channel=%q,host=%q} %d\n", ch.Topic, ch.Channel, host.Host, host.MessageCount)
+			}
+		}
+	})
+
+	gauge("nsqtop_cluster_total_depth", "Sum of depth across all channels in the cluster.", func() {
+		fmt.Fprintf(&b, "nsqtop_cluster_total_depth %d\n", snapshot.TotalDepth)
+	})
+
+	gauge("nsqtop_cluster_total_in_flight", "Sum of in-flight messages across all channels in the cluster.", func() {
+		fmt.Fprintf(&b, "nsqtop_cluster_total_in_flight %d\n", snapshot.TotalInFlight)
+	})
+
+	gauge("nsqtop_nsqd_nodes", "Number of nsqd nodes discovered via nsqlookupd.", func() {
+		fmt.Fprintf(&b, "nsqtop_nsqd_nodes %d\n", len(snapshot.Nodes))
+	})
+
+	return b.String()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/FleetChaser/nsqtop/cluster"
+)
+
+func TestFormatPrometheus(t *testing.T) {
+	snapshot := &cluster.ClusterSnapshot{
+		Nodes: []cluster.Producer{{BroadcastAddress: "nsqd1", HTTPPort: 4151}},
+		Channels: []cluster.ChannelStats{
+			{
+				Topic:         "orders",
+				Channel:       "worker",
+				Depth:         5,
+				InFlightCount: 2,
+				MessageCount:  100,
+				RatePerSecond: 1.5,
+				RateEWMA1m:    1.2,
+				Hosts: []cluster.HostChannelStats{
+					{Host: "nsqd1:4151", Depth: 3, BackendDepth: 2, InFlightCount: 1, MessageCount: 100},
+				},
+			},
+		},
+		TotalDepth:    5,
+		TotalInFlight: 2,
+	}
+
+	out := formatPrometheus(snapshot)
+
+	for _, want := range []string{
+		`nsqtop_channel_depth{topic="orders",channel="worker"} 5`,
+		`nsqtop_channel_backend_depth{topic="orders",channel="worker"} 2`,
+		`nsqtop_host_channel_depth{topic="orders",channel="worker",host="nsqd1:4151"} 3`,
+		`nsqtop_cluster_total_depth 5`,
+		`nsqtop_nsqd_nodes 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("formatPrometheus output missing %q\ngot:\n%s", want, out)
+		}
+	}
+}
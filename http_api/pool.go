@@ -0,0 +1,35 @@
+package http_api
+
+import "sync"
+
+// RunPool calls fn(i) for every i in [0,n), running at most concurrency
+// calls at once, and blocks until all of them have returned. It does not
+// fail fast or collect results itself: fn is expected to record its own
+// outcome (e.g. by writing to a pre-sized slice at index i), which is safe
+// since each index is only ever touched by its own call.
+func RunPool(n, concurrency int, fn func(i int)) {
+	if n == 0 {
+		return
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,121 @@
+// Package lookupd talks to one or more nsqlookupd instances.
+package lookupd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/FleetChaser/nsqtop/http_api"
+)
+
+// Producer is a single nsqd node as reported by nsqlookupd's /nodes
+// endpoint.
+type Producer struct {
+	BroadcastAddress string `json:"broadcast_address"`
+	HTTPPort         int    `json:"http_port"`
+}
+
+type nodesResponse struct {
+	Producers []Producer `json:"producers"`
+}
+
+const defaultConcurrency = 8
+
+// Client queries one or more nsqlookupd instances for the nsqd nodes
+// registered with them.
+type Client struct {
+	api  *http_api.Client
+	urls []string
+
+	// Concurrency bounds how many lookupd instances are queried at once.
+	Concurrency int
+}
+
+// NewClient returns a Client that queries the given nsqlookupd HTTP
+// addresses (e.g. "http://localhost:4161") through api.
+func NewClient(urls []string, api *http_api.Client) *Client {
+	return &Client{api: api, urls: urls, Concurrency: defaultConcurrency}
+}
+
+// GetNodes queries every configured lookupd concurrently and returns the
+// de-duplicated union of nsqd nodes they report. It fails only when no
+// lookupd reported any node and at least one lookupd failed; otherwise the
+// nodes from the healthy subset are returned with the unhealthy ones
+// silently excluded, matching nsqtop's historical tolerance for a flaky
+// lookupd.
+func (c *Client) GetNodes(ctx context.Context) ([]Producer, error) {
+	results := make([][]Producer, len(c.urls))
+	errs := make([]error, len(c.urls))
+
+	http_api.RunPool(len(c.urls), c.Concurrency, func(i int) {
+		var resp nodesResponse
+		if err := c.api.GetJSON(ctx, c.urls[i]+"/nodes", &resp); err != nil {
+			errs[i] = fmt.Errorf("failed to connect to %s: %w", c.urls[i], err)
+			return
+		}
+		results[i] = resp.Producers
+	})
+
+	var allProducers []Producer
+	var errMsgs []string
+	for i, err := range errs {
+		if err != nil {
+			errMsgs = append(errMsgs, err.Error())
+			continue
+		}
+		allProducers = append(allProducers, results[i]...)
+	}
+
+	if len(allProducers) == 0 && len(errMsgs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errMsgs, "; "))
+	}
+
+	seen := make(map[string]bool)
+	var unique []Producer
+	for _, producer := range allProducers {
+		key := fmt.Sprintf("%s:%d", producer.BroadcastAddress, producer.HTTPPort)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, producer)
+		}
+	}
+
+	return unique, nil
+}
+
+// TombstoneTopicProducer tells every configured nsqlookupd to tombstone
+// node (a single nsqd "broadcast_address:http_port") as a producer of
+// topic, so it stops being returned by lookups until the tombstone
+// expires or the node re-registers. It's how a topic delete is made to
+// "stick" on a specific nsqd without the node racing to re-announce
+// itself. It returns the HTTP status from the last lookupd that accepted
+// the request, for audit purposes.
+func (c *Client) TombstoneTopicProducer(ctx context.Context, topic, node string) (string, error) {
+	params := url.Values{"topic": {topic}, "node": {node}}
+
+	var status string
+	var errMsgs []string
+	for _, lookupURL := range c.urls {
+		target := lookupURL + "/topic/tombstone_topic_producer?" + params.Encode()
+
+		resp, err := c.api.PostAction(ctx, target)
+		if err != nil {
+			errMsgs = append(errMsgs, fmt.Sprintf("%s: %v", lookupURL, err))
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 400 {
+			errMsgs = append(errMsgs, fmt.Sprintf("%s: %s", lookupURL, resp.Status))
+			continue
+		}
+		status = resp.Status
+	}
+
+	if len(errMsgs) > 0 {
+		return status, fmt.Errorf("%s", strings.Join(errMsgs, "; "))
+	}
+	return status, nil
+}
@@ -43,7 +43,7 @@ func TestGenerateSparkline(t *testing.T) {
 		if runeCount != test.expected {
 			t.Errorf("generateSparkline(%v) rune length = %d; expected %d", test.input, runeCount, test.expected)
 		}
-		
+
 		// Additional check: ensure we're getting valid sparkline characters
 		if len(test.input) > 0 {
 			for _, r := range result {
@@ -0,0 +1,168 @@
+// Package nsqd talks to an individual nsqd's HTTP API.
+package nsqd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/FleetChaser/nsqtop/http_api"
+)
+
+// Percentile is a single point on an e2e_processing_latency histogram.
+type Percentile struct {
+	Quantile float64 `json:"quantile"`
+	Value    int64   `json:"value"` // nanoseconds
+}
+
+// E2EProcessingLatency is nsqd's end-to-end processing latency histogram
+// for a topic or channel, present only when nsqd is configured with
+// --e2e-processing-latency-percentiles.
+type E2EProcessingLatency struct {
+	Count       int          `json:"count"`
+	Percentiles []Percentile `json:"percentiles"`
+}
+
+// ClientStats is a single consumer connected to a channel. Only populated
+// when /stats is queried with include_clients=true.
+type ClientStats struct {
+	ClientID string `json:"client_id"`
+}
+
+// Channel is a single channel's stats as reported by nsqd's /stats
+// endpoint.
+type Channel struct {
+	ChannelName          string               `json:"channel_name"`
+	Depth                int                  `json:"depth"`
+	BackendDepth         int                  `json:"backend_depth"`
+	InFlightCount        int                  `json:"in_flight_count"`
+	DeferredCount        int                  `json:"deferred_count"`
+	MessageCount         int64                `json:"message_count"`
+	RequeueCount         int64                `json:"requeue_count"`
+	TimeoutCount         int64                `json:"timeout_count"`
+	Paused               bool                 `json:"paused"`
+	E2EProcessingLatency E2EProcessingLatency `json:"e2e_processing_latency"`
+	Clients              []ClientStats        `json:"clients"`
+}
+
+type Topic struct {
+	TopicName            string               `json:"topic_name"`
+	Channels             []Channel            `json:"channels"`
+	Paused               bool                 `json:"paused"`
+	E2EProcessingLatency E2EProcessingLatency `json:"e2e_processing_latency"`
+}
+
+type StatsResponse struct {
+	Topics []Topic        `json:"topics"`
+	Data   *StatsResponse `json:"data,omitempty"` // For newer NSQ versions
+}
+
+// Client queries a single nsqd's /stats endpoint. Broadcast addresses come
+// from nsqlookupd and never carry a scheme or credentials of their own, so
+// Scheme and Userinfo let the caller reuse whatever scheme/auth it used to
+// reach nsqlookupd.
+type Client struct {
+	api      *http_api.Client
+	Scheme   string
+	Userinfo *url.Userinfo
+}
+
+// NewClient returns a Client that issues requests through api using
+// scheme ("http" or "https", defaulting to "http") and, if non-nil,
+// userinfo as HTTP basic auth credentials.
+func NewClient(api *http_api.Client, scheme string, userinfo *url.Userinfo) *Client {
+	if scheme == "" {
+		scheme = "http"
+	}
+	return &Client{api: api, Scheme: scheme, Userinfo: userinfo}
+}
+
+// GetStats fetches and decodes /stats?format=json&include_clients=true from
+// the nsqd at broadcastAddress:httpPort, unwrapping the nested "data"
+// envelope used by newer nsqd versions.
+func (c *Client) GetStats(ctx context.Context, broadcastAddress string, httpPort int) (*StatsResponse, error) {
+	u := url.URL{
+		Scheme: c.Scheme,
+		User:   c.Userinfo,
+		Host:   fmt.Sprintf("%s:%d", broadcastAddress, httpPort),
+		Path:   "/stats",
+	}
+	q := u.Query()
+	q.Set("format", "json")
+	q.Set("include_clients", "true")
+	u.RawQuery = q.Encode()
+
+	var stats StatsResponse
+	if err := c.api.GetJSON(ctx, u.String(), &stats); err != nil {
+		return nil, err
+	}
+	if stats.Data != nil {
+		return stats.Data, nil
+	}
+	return &stats, nil
+}
+
+// PauseChannel stops message delivery on a channel without affecting its
+// topic. It returns nsqd's HTTP response status (e.g. "200 OK") on success.
+func (c *Client) PauseChannel(ctx context.Context, broadcastAddress string, httpPort int, topic, channel string) (string, error) {
+	return c.postAction(ctx, broadcastAddress, httpPort, "/channel/pause", url.Values{"topic": {topic}, "channel": {channel}})
+}
+
+// UnpauseChannel resumes message delivery on a previously-paused channel.
+func (c *Client) UnpauseChannel(ctx context.Context, broadcastAddress string, httpPort int, topic, channel string) (string, error) {
+	return c.postAction(ctx, broadcastAddress, httpPort, "/channel/unpause", url.Values{"topic": {topic}, "channel": {channel}})
+}
+
+// EmptyChannel discards every message currently queued on a channel.
+func (c *Client) EmptyChannel(ctx context.Context, broadcastAddress string, httpPort int, topic, channel string) (string, error) {
+	return c.postAction(ctx, broadcastAddress, httpPort, "/channel/empty", url.Values{"topic": {topic}, "channel": {channel}})
+}
+
+// DeleteChannel permanently removes a channel from its topic.
+func (c *Client) DeleteChannel(ctx context.Context, broadcastAddress string, httpPort int, topic, channel string) (string, error) {
+	return c.postAction(ctx, broadcastAddress, httpPort, "/channel/delete", url.Values{"topic": {topic}, "channel": {channel}})
+}
+
+// PauseTopic stops message delivery for every channel on a topic.
+func (c *Client) PauseTopic(ctx context.Context, broadcastAddress string, httpPort int, topic string) (string, error) {
+	return c.postAction(ctx, broadcastAddress, httpPort, "/topic/pause", url.Values{"topic": {topic}})
+}
+
+// UnpauseTopic resumes message delivery on a previously-paused topic.
+func (c *Client) UnpauseTopic(ctx context.Context, broadcastAddress string, httpPort int, topic string) (string, error) {
+	return c.postAction(ctx, broadcastAddress, httpPort, "/topic/unpause", url.Values{"topic": {topic}})
+}
+
+// EmptyTopic discards every message currently queued on a topic.
+func (c *Client) EmptyTopic(ctx context.Context, broadcastAddress string, httpPort int, topic string) (string, error) {
+	return c.postAction(ctx, broadcastAddress, httpPort, "/topic/empty", url.Values{"topic": {topic}})
+}
+
+// DeleteTopic permanently removes a topic and all of its channels.
+func (c *Client) DeleteTopic(ctx context.Context, broadcastAddress string, httpPort int, topic string) (string, error) {
+	return c.postAction(ctx, broadcastAddress, httpPort, "/topic/delete", url.Values{"topic": {topic}})
+}
+
+// postAction issues the mutating request and returns nsqd's HTTP response
+// status string (e.g. "200 OK") on success, so callers can audit the
+// actual HTTP response rather than just a boolean outcome.
+func (c *Client) postAction(ctx context.Context, broadcastAddress string, httpPort int, path string, params url.Values) (string, error) {
+	u := url.URL{
+		Scheme:   c.Scheme,
+		User:     c.Userinfo,
+		Host:     fmt.Sprintf("%s:%d", broadcastAddress, httpPort),
+		Path:     path,
+		RawQuery: params.Encode(),
+	}
+
+	resp, err := c.api.PostAction(ctx, u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return "", &http_api.StatusError{URL: u.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return resp.Status, nil
+}
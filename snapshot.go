@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/FleetChaser/nsqtop/cluster"
+)
+
+// runSnapshot performs a single Scrape and prints the result as a JSON
+// document to stdout. format is currently validated to be "json"; it's a
+// parameter rather than hard-coded so a future format doesn't need a flag
+// rename.
+func runSnapshot(scraper *cluster.Scraper, format string) {
+	if format != "json" {
+		log.Fatalf("unsupported --snapshot format %q (only \"json\" is supported)", format)
+	}
+
+	snapshot, err := scraper.Scrape(context.Background())
+	if err != nil {
+		log.Fatalf("snapshot failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snapshot); err != nil {
+		log.Fatalf("failed to encode snapshot: %v", err)
+	}
+}
@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/FleetChaser/nsqtop/cluster"
+	"github.com/FleetChaser/nsqtop/lookupd"
+	"github.com/FleetChaser/nsqtop/nsqd"
+)
+
+// viewMode selects what the main table's rows represent.
+type viewMode int
+
+const (
+	viewChannels viewMode = iota // rows = topic/channel pairs (default)
+	viewTopics                   // rows = topics, summed over their channels
+	viewNSQD                     // rows = nsqd nodes, columns = topics
+)
+
+// sortColumn selects how rows within a view are ordered. 's' cycles
+// through these.
+type sortColumn int
+
+const (
+	sortByDepth sortColumn = iota
+	sortByInFlight
+	sortByRate
+	sortByName
+	sortColumnCount
+)
+
+func (c sortColumn) String() string {
+	switch c {
+	case sortByDepth:
+		return "Depth"
+	case sortByInFlight:
+		return "In-Flight"
+	case sortByRate:
+		return "Rate"
+	case sortByName:
+		return "Name"
+	default:
+		return "?"
+	}
+}
+
+// rowRef identifies what a table row drills down to when Enter is
+// pressed. Exactly one of (topic+channel), topic, or host is meaningful,
+// depending on the active viewMode.
+type rowRef struct {
+	topic   string
+	channel string
+	host    string
+}
+
+const detailPageName = "detail"
+const filterPageName = "filter"
+
+type NSQTop struct {
+	app     *tview.Application
+	pages   *tview.Pages
+	table   *tview.Table
+	summary *tview.TextView
+	helpBar *tview.TextView
+	scraper *cluster.Scraper
+
+	lookupURLs      []string
+	interval        time.Duration
+	inflightHistory []int
+
+	// actionsEnabled gates the P/U/E/D/T admin-action keybindings (see
+	// action.go). nsqdClient/lookupdClient/auditLog are only set when
+	// actionsEnabled is true.
+	actionsEnabled bool
+	auditLog       *log.Logger
+	nsqdClient     *nsqd.Client
+	lookupdClient  *lookupd.Client
+
+	snapshot  *cluster.ClusterSnapshot
+	viewMode  viewMode
+	sortCol   sortColumn
+	filterRe  *regexp.Regexp
+	filterSrc string
+	paused    bool
+	rows      []rowRef
+}
+
+func (n *NSQTop) initUI() {
+	n.app = tview.NewApplication()
+
+	// Create summary view
+	n.summary = tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(false)
+	n.summary.SetBorder(true).SetTitle("NSQ Cluster Status")
+
+	// Create table
+	n.table = tview.NewTable().
+		SetBorders(true).
+		SetSelectable(true, false).
+		SetFixed(1, 0)
+	n.table.SetSelectedFunc(n.onRowSelected)
+
+	helpText := "[grey]t[white] topics  [grey]n[white] per-nsqd  [grey]c[white] channels  " +
+		"[grey]/[white] filter  [grey]s[white] sort  [grey]p[white] pause  [grey]Enter[white] detail  [grey]Ctrl+C[white] quit"
+	if n.actionsEnabled {
+		helpText += "  |  [grey]P[white] pause  [grey]U[white] unpause  [grey]E[white] empty  [grey]D[white] delete  [grey]T[white] tombstone"
+	}
+	n.helpBar = tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(helpText)
+
+	// Set up layout
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(n.summary, 5, 1, false).
+		AddItem(n.table, 0, 1, true).
+		AddItem(n.helpBar, 1, 1, false)
+
+	n.pages = tview.NewPages().
+		AddPage("main", flex, true, true)
+
+	n.app.SetRoot(n.pages, true).SetFocus(n.table)
+
+	n.table.SetInputCapture(n.handleTableKey)
+
+	// Handle Ctrl+C
+	n.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlC {
+			n.app.Stop()
+		}
+		return event
+	})
+}
+
+func (n *NSQTop) startMonitoring() {
+	go func() {
+		ticker := time.NewTicker(n.interval)
+		defer ticker.Stop()
+
+		// Initial update
+		n.updateData()
+
+		for range ticker.C {
+			n.updateData()
+		}
+	}()
+}
+
+func (n *NSQTop) updateData() {
+	if n.paused {
+		return
+	}
+
+	snapshot, err := n.scraper.Scrape(context.Background())
+	if err != nil {
+		n.app.QueueUpdateDraw(func() {
+			n.summary.SetText(fmt.Sprintf("[red]Error: %s[white]", err.Error()))
+		})
+		return
+	}
+
+	// Update inflight history
+	n.inflightHistory = append(n.inflightHistory, snapshot.TotalInFlight)
+	if len(n.inflightHistory) > SparklineLength {
+		n.inflightHistory = n.inflightHistory[1:]
+	}
+
+	n.app.QueueUpdateDraw(func() {
+		n.snapshot = snapshot
+		n.render()
+	})
+}
+
+// render redraws the summary and table from n.snapshot using the current
+// view mode, sort column and filter. It's called both after a fresh
+// Scrape and whenever the user changes view/sort/filter, so it only reads
+// n.snapshot and never scrapes or replaces it itself.
+func (n *NSQTop) render() {
+	if n.snapshot == nil {
+		return
+	}
+	n.renderSummary()
+
+	switch n.viewMode {
+	case viewTopics:
+		n.renderTopicsView()
+	case viewNSQD:
+		n.renderNSQDView()
+	default:
+		n.renderChannelsView()
+	}
+}
+
+func (n *NSQTop) renderSummary() {
+	snapshot := n.snapshot
+	sparkline := generateSparkline(n.inflightHistory)
+	lookupDisplay := strings.Join(n.lookupURLs, ", ")
+	if len(n.lookupURLs) > 3 {
+		lookupDisplay = fmt.Sprintf("%d servers", len(n.lookupURLs))
+	}
+
+	var nsqdServers []string
+	for _, node := range snapshot.Nodes {
+		nsqdServers = append(nsqdServers, fmt.Sprintf("%s:%d", node.BroadcastAddress, node.HTTPPort))
+	}
+	nsqdDisplay := strings.Join(nsqdServers, ", ")
+	if len(nsqdServers) > 3 {
+		nsqdDisplay = fmt.Sprintf("%d nsqd nodes", len(nsqdServers))
+	}
+
+	status := ""
+	if n.paused {
+		status = " [red](paused)[white]"
+	}
+	filterStatus := ""
+	if n.filterRe != nil {
+		filterStatus = fmt.Sprintf(" | Filter: /%s/", n.filterSrc)
+	}
+
+	summaryText := fmt.Sprintf(
+		"[blue]NSQ Top - %s - Connected to %s[white]%s\n"+
+			"[yellow]Total Depth: %s | Total In-Flight: %s | Channels: %d | Trend: %s[white]\n"+
+			"[green]NSQd Servers: %s[white] | Sort: %s%s",
+		time.Now().Format("2006-01-02 15:04:05"),
+		lookupDisplay,
+		status,
+		formatNumber(snapshot.TotalDepth),
+		formatNumber(snapshot.TotalInFlight),
+		len(snapshot.Channels),
+		sparkline,
+		nsqdDisplay,
+		n.sortCol,
+		filterStatus,
+	)
+	n.summary.SetText(summaryText)
+}
+
+func depthCell(depth int) *tview.TableCell {
+	cell := tview.NewTableCell(formatNumber(depth)).SetAlign(tview.AlignRight)
+	if depth >= DepthCritThreshold {
+		cell.SetTextColor(tcell.ColorRed)
+	} else if depth >= DepthWarnThreshold {
+		cell.SetTextColor(tcell.ColorYellow)
+	} else {
+		cell.SetTextColor(tcell.ColorGreen)
+	}
+	return cell
+}
+
+func rateText(perSecond float64) string {
+	if perSecond > 0 {
+		return fmt.Sprintf("%.1f", perSecond)
+	}
+	return "--"
+}
+
+// generateSparklineF is generateSparkline for float64 samples (per-channel
+// rate history), which doesn't fit the []int history tracked cluster-wide.
+func generateSparklineF(history []float64) string {
+	if len(history) == 0 {
+		return ""
+	}
+
+	max := 0.0
+	for _, val := range history {
+		if val > max {
+			max = val
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	sparklineRunes := []rune(SparklineChars)
+
+	var result strings.Builder
+	for _, val := range history {
+		index := int(val * float64(len(sparklineRunes)-1) / max)
+		if index >= len(sparklineRunes) {
+			index = len(sparklineRunes) - 1
+		} else if index < 0 {
+			index = 0
+		}
+		result.WriteRune(sparklineRunes[index])
+	}
+
+	return result.String()
+}
+
+func setHeaders(table *tview.Table, headers []string) {
+	for i, header := range headers {
+		cell := tview.NewTableCell(header).
+			SetAlign(tview.AlignCenter).
+			SetAttributes(tcell.AttrBold).
+			SetBackgroundColor(tcell.ColorDarkBlue)
+		table.SetCell(0, i, cell)
+	}
+}
+
+func (n *NSQTop) renderChannelsView() {
+	channels := make([]cluster.ChannelStats, 0, len(n.snapshot.Channels))
+	for _, ch := range n.snapshot.Channels {
+		if n.filterRe != nil && !n.filterRe.MatchString(ch.Topic+"/"+ch.Channel) {
+			continue
+		}
+		channels = append(channels, ch)
+	}
+	n.sortChannels(channels)
+
+	n.table.Clear()
+	setHeaders(n.table, []string{"Topic/Channel", "Depth", "In-Flight", "Rate/sec", "1m", "5m", "15m", "p99", "Trend"})
+
+	n.rows = make([]rowRef, len(channels))
+	for i, channel := range channels {
+		row := i + 1
+		n.rows[i] = rowRef{topic: channel.Topic, channel: channel.Channel}
+
+		n.table.SetCell(row, 0, tview.NewTableCell(fmt.Sprintf("%s/%s", channel.Topic, channel.Channel)))
+		n.table.SetCell(row, 1, depthCell(channel.Depth))
+		n.table.SetCell(row, 2, tview.NewTableCell(formatNumber(channel.InFlightCount)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 3, tview.NewTableCell(rateText(channel.RatePerSecond)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 4, tview.NewTableCell(rateText(channel.RateEWMA1m)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 5, tview.NewTableCell(rateText(channel.RateEWMA5m)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 6, tview.NewTableCell(rateText(channel.RateEWMA15m)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 7, tview.NewTableCell(formatLatency(channel.Latency.P99)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 8, tview.NewTableCell(generateSparklineF(channel.History)))
+	}
+}
+
+func (n *NSQTop) renderTopicsView() {
+	topics := make([]cluster.TopicStats, 0, len(n.snapshot.Topics))
+	for _, t := range n.snapshot.Topics {
+		if n.filterRe != nil && !n.filterRe.MatchString(t.Topic) {
+			continue
+		}
+		topics = append(topics, t)
+	}
+	n.sortTopics(topics)
+
+	n.table.Clear()
+	setHeaders(n.table, []string{"Topic", "Depth", "In-Flight", "Rate/sec", "1m", "5m", "15m", "Channels", "p99"})
+
+	n.rows = make([]rowRef, len(topics))
+	for i, topic := range topics {
+		row := i + 1
+		n.rows[i] = rowRef{topic: topic.Topic}
+
+		n.table.SetCell(row, 0, tview.NewTableCell(topic.Topic))
+		n.table.SetCell(row, 1, depthCell(topic.Depth))
+		n.table.SetCell(row, 2, tview.NewTableCell(formatNumber(topic.InFlightCount)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 3, tview.NewTableCell(rateText(topic.RatePerSecond)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 4, tview.NewTableCell(rateText(topic.RateEWMA1m)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 5, tview.NewTableCell(rateText(topic.RateEWMA5m)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 6, tview.NewTableCell(rateText(topic.RateEWMA15m)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 7, tview.NewTableCell(formatNumber(topic.ChannelCount)).SetAlign(tview.AlignRight))
+		n.table.SetCell(row, 8, tview.NewTableCell(formatLatency(topic.Latency.P99)).SetAlign(tview.AlignRight))
+	}
+}
+
+// renderNSQDView lays out rows = nsqd nodes, columns = topics, each cell
+// the summed depth of that node's channels for that topic.
+func (n *NSQTop) renderNSQDView() {
+	topicNames := make([]string, 0, len(n.snapshot.Topics))
+	for _, t := range n.snapshot.Topics {
+		if n.filterRe != nil && !n.filterRe.MatchString(t.Topic) {
+			continue
+		}
+		topicNames = append(topicNames, t.Topic)
+	}
+	sort.Strings(topicNames)
+	topicIndex := make(map[string]int, len(topicNames))
+	for i, name := range topicNames {
+		topicIndex[name] = i
+	}
+
+	type hostRow struct {
+		host   string
+		depths []int
+		total  int
+	}
+	hostRows := make(map[string]*hostRow)
+	for _, ch := range n.snapshot.Channels {
+		idx, ok := topicIndex[ch.Topic]
+		if !ok {
+			continue
+		}
+		for _, host := range ch.Hosts {
+			hr, exists := hostRows[host.Host]
+			if !exists {
+				hr = &hostRow{host: host.Host, depths: make([]int, len(topicNames))}
+				hostRows[host.Host] = hr
+			}
+			hr.depths[idx] += host.Depth + host.BackendDepth
+			hr.total += host.Depth + host.BackendDepth
+		}
+	}
+
+	rows := make([]*hostRow, 0, len(hostRows))
+	for _, hr := range hostRows {
+		rows = append(rows, hr)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if n.sortCol == sortByName {
+			return rows[i].host < rows[j].host
+		}
+		return rows[i].total > rows[j].total
+	})
+
+	n.table.Clear()
+	headers := append([]string{"nsqd"}, topicNames...)
+	setHeaders(n.table, headers)
+
+	n.rows = make([]rowRef, len(rows))
+	for i, hr := range rows {
+		row := i + 1
+		n.rows[i] = rowRef{host: hr.host}
+		n.table.SetCell(row, 0, tview.NewTableCell(hr.host))
+		for c, depth := range hr.depths {
+			n.table.SetCell(row, c+1, depthCell(depth))
+		}
+	}
+}
+
+func (n *NSQTop) sortChannels(channels []cluster.ChannelStats) {
+	sort.Slice(channels, func(i, j int) bool {
+		switch n.sortCol {
+		case sortByInFlight:
+			return channels[i].InFlightCount > channels[j].InFlightCount
+		case sortByRate:
+			return channels[i].RatePerSecond > channels[j].RatePerSecond
+		case sortByName:
+			return channels[i].Topic+"/"+channels[i].Channel < channels[j].Topic+"/"+channels[j].Channel
+		default:
+			return channels[i].Depth > channels[j].Depth
+		}
+	})
+}
+
+func (n *NSQTop) sortTopics(topics []cluster.TopicStats) {
+	sort.Slice(topics, func(i, j int) bool {
+		switch n.sortCol {
+		case sortByInFlight:
+			return topics[i].InFlightCount > topics[j].InFlightCount
+		case sortByRate:
+			return topics[i].RatePerSecond > topics[j].RatePerSecond
+		case sortByName:
+			return topics[i].Topic < topics[j].Topic
+		default:
+			return topics[i].Depth > topics[j].Depth
+		}
+	})
+}
+
+func formatLatency(d time.Duration) string {
+	if d == 0 {
+		return "--"
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+// handleTableKey implements the view/sort/filter/pause keybindings. Enter
+// is handled separately via SetSelectedFunc.
+func (n *NSQTop) handleTableKey(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Rune() {
+	case 't':
+		n.viewMode = viewTopics
+		n.render()
+		return nil
+	case 'n':
+		n.viewMode = viewNSQD
+		n.render()
+		return nil
+	case 'c':
+		n.viewMode = viewChannels
+		n.render()
+		return nil
+	case 's':
+		n.sortCol = (n.sortCol + 1) % sortColumnCount
+		n.render()
+		return nil
+	case 'p':
+		n.paused = !n.paused
+		n.render()
+		return nil
+	case '/':
+		n.showFilterPrompt()
+		return nil
+	}
+	if n.handleActionKey(event.Rune()) {
+		return nil
+	}
+	return event
+}
+
+func (n *NSQTop) showFilterPrompt() {
+	input := tview.NewInputField().
+		SetLabel("Filter (regex): ").
+		SetText(n.filterSrc).
+		SetFieldWidth(40)
+
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			pattern := input.GetText()
+			if pattern == "" {
+				n.filterRe = nil
+				n.filterSrc = ""
+			} else if re, err := regexp.Compile(pattern); err == nil {
+				n.filterRe = re
+				n.filterSrc = pattern
+			}
+		}
+		n.pages.RemovePage(filterPageName)
+		n.app.SetFocus(n.table)
+		n.render()
+	})
+
+	frame := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(nil, 0, 1, false).
+		AddItem(input, 1, 0, true).
+		AddItem(nil, 0, 1, false)
+
+	n.pages.AddPage(filterPageName, frame, true, true)
+	n.app.SetFocus(input)
+}
+
+// onRowSelected opens a detail pane for the selected row's topic, channel,
+// or nsqd host, depending on the active view mode.
+func (n *NSQTop) onRowSelected(row, column int) {
+	if n.snapshot == nil || row < 1 || row-1 >= len(n.rows) {
+		return
+	}
+	ref := n.rows[row-1]
+
+	var text string
+	switch {
+	case ref.host != "":
+		text = n.hostDetailText(ref.host)
+	case ref.channel != "":
+		text = n.channelDetailText(ref.topic, ref.channel)
+	default:
+		text = n.topicDetailText(ref.topic)
+	}
+
+	n.showDetail(text)
+}
+
+func (n *NSQTop) showDetail(text string) {
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(text)
+	view.SetBorder(true).SetTitle("Detail (Esc to close)")
+
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape {
+			n.pages.RemovePage(detailPageName)
+			n.app.SetFocus(n.table)
+			return nil
+		}
+		return event
+	})
+
+	frame := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(view, 0, 3, true).
+			AddItem(nil, 0, 1, false), 0, 3, true).
+		AddItem(nil, 0, 1, false)
+
+	n.pages.AddPage(detailPageName, frame, true, true)
+	n.app.SetFocus(view)
+}
+
+func (n *NSQTop) channelDetailText(topic, channel string) string {
+	for _, ch := range n.snapshot.Channels {
+		if ch.Topic != topic || ch.Channel != channel {
+			continue
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "[yellow]%s/%s[white]\n\n", topic, channel)
+		fmt.Fprintf(&b, "Depth: %s   In-Flight: %s   Rate/sec: %s\n",
+			formatNumber(ch.Depth), formatNumber(ch.InFlightCount), rateText(ch.RatePerSecond))
+		fmt.Fprintf(&b, "EWMA rate: 1m=%s 5m=%s 15m=%s\n\n",
+			rateText(ch.RateEWMA1m), rateText(ch.RateEWMA5m), rateText(ch.RateEWMA15m))
+		fmt.Fprintf(&b, "E2E latency (avg across hosts): p50=%s p95=%s p99=%s p999=%s\n\n",
+			formatLatency(ch.Latency.P50), formatLatency(ch.Latency.P95),
+			formatLatency(ch.Latency.P99), formatLatency(ch.Latency.P999))
+
+		fmt.Fprintf(&b, "[green]Per-nsqd breakdown:[white]\n")
+		for _, host := range ch.Hosts {
+			paused := ""
+			if host.Paused {
+				paused = " [red](paused)[white]"
+			}
+			fmt.Fprintf(&b, "  %-22s depth=%-8s in-flight=%-6d deferred=%-6d requeued=%-6d timeouts=%-6d clients=%-4d%s\n",
+				host.Host, formatNumber(host.Depth+host.BackendDepth), host.InFlightCount,
+				host.DeferredCount, host.RequeueCount, host.TimeoutCount, host.ClientCount, paused)
+		}
+		return b.String()
+	}
+	return fmt.Sprintf("%s/%s not found in the latest scrape", topic, channel)
+}
+
+func (n *NSQTop) topicDetailText(topic string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[yellow]%s[white] (topic aggregate)\n\n", topic)
+
+	for _, t := range n.snapshot.Topics {
+		if t.Topic != topic {
+			continue
+		}
+		fmt.Fprintf(&b, "Depth: %s   In-Flight: %s   Rate/sec: %s   Channels: %d\n\n",
+			formatNumber(t.Depth), formatNumber(t.InFlightCount), rateText(t.RatePerSecond), t.ChannelCount)
+		fmt.Fprintf(&b, "E2E latency (avg across hosts): p50=%s p95=%s p99=%s p999=%s\n\n",
+			formatLatency(t.Latency.P50), formatLatency(t.Latency.P95),
+			formatLatency(t.Latency.P99), formatLatency(t.Latency.P999))
+		break
+	}
+
+	fmt.Fprintf(&b, "[green]Channels:[white]\n")
+	for _, ch := range n.snapshot.Channels {
+		if ch.Topic != topic {
+			continue
+		}
+		fmt.Fprintf(&b, "  %-30s depth=%-8s in-flight=%-6d rate/sec=%s\n",
+			ch.Channel, formatNumber(ch.Depth), ch.InFlightCount, rateText(ch.RatePerSecond))
+	}
+	return b.String()
+}
+
+func (n *NSQTop) hostDetailText(host string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[yellow]%s[white]\n\n", host)
+
+	for _, ch := range n.snapshot.Channels {
+		for _, h := range ch.Hosts {
+			if h.Host != host {
+				continue
+			}
+			fmt.Fprintf(&b, "  %-30s depth=%-8s in-flight=%-6d clients=%-4d\n",
+				ch.Topic+"/"+ch.Channel, formatNumber(h.Depth+h.BackendDepth), h.InFlightCount, h.ClientCount)
+		}
+	}
+	return b.String()
+}
+
+func generateSparkline(history []int) string {
+	if len(history) == 0 {
+		return ""
+	}
+
+	max := 0
+	for _, val := range history {
+		if val > max {
+			max = val
+		}
+	}
+
+	if max == 0 {
+		max = 1
+	}
+
+	// Convert string to rune slice to handle Unicode characters properly
+	sparklineRunes := []rune(SparklineChars)
+
+	var result strings.Builder
+	for _, val := range history {
+		index := (val * (len(sparklineRunes) - 1)) / max
+		if index >= len(sparklineRunes) {
+			index = len(sparklineRunes) - 1
+		}
+		result.WriteRune(sparklineRunes[index])
+	}
+
+	return result.String()
+}